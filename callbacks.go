@@ -0,0 +1,105 @@
+package connpool
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// EvictReason describes why a pooled *Conn was discarded instead of
+// being handed back out, for OnEvict callbacks.
+type EvictReason int
+
+const (
+	// EvictMaxLifetime means the conn exceeded its MaxLifetime.
+	EvictMaxLifetime EvictReason = iota
+	// EvictIdleTimeout means the conn sat unused longer than IdleTimeout.
+	EvictIdleTimeout
+	// EvictBroken means a prior operation on the conn returned an error.
+	EvictBroken
+	// EvictDead means the pre-reuse liveness probe found the peer had
+	// closed the conn, or left unexpected data on it.
+	EvictDead
+	// EvictValidationFailed means the user-supplied Validator rejected
+	// the conn.
+	EvictValidationFailed
+	// EvictNotPoolable means the conn's underlying net.Conn doesn't
+	// implement syscall.Conn, see ErrNotSyscallConn.
+	EvictNotPoolable
+	// EvictIdleCapacity means the pool already held MaxIdleConns idle
+	// conns for this cacheKey.
+	EvictIdleCapacity
+	// EvictDialerClosed means the Dialer was closed.
+	EvictDialerClosed
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictMaxLifetime:
+		return "max lifetime exceeded"
+	case EvictIdleTimeout:
+		return "idle timeout exceeded"
+	case EvictBroken:
+		return "broken"
+	case EvictDead:
+		return "dead"
+	case EvictValidationFailed:
+		return "validation failed"
+	case EvictNotPoolable:
+		return "not poolable"
+	case EvictIdleCapacity:
+		return "idle capacity exceeded"
+	case EvictDialerClosed:
+		return "dialer closed"
+	default:
+		return "unknown"
+	}
+}
+
+// WithValidator supplies a protocol-aware liveness check run before a
+// cached *Conn is returned from DialContext, e.g. sending a Redis PING,
+// an HTTP/2 PING frame, or an AMQP heartbeat. It's passed the context
+// given to DialContext, so callers can bound the extra round trip. A
+// non-nil error evicts and closes the conn (reported via OnEvict as
+// EvictValidationFailed) and the pool falls back to dialing fresh.
+func WithValidator(validator func(context.Context, net.Conn) error) Option {
+	return func(d *Dialer) {
+		d.validator = validator
+	}
+}
+
+// WithOnEvict registers a callback invoked whenever a pooled *Conn is
+// discarded instead of reused, so operators can emit metrics without
+// this package depending on any metrics library. It runs synchronously
+// on whatever goroutine triggered the eviction, sometimes with d.mu
+// held, so it must not call back into the Dialer or the *Conn.
+func WithOnEvict(onEvict func(*Conn, EvictReason)) Option {
+	return func(d *Dialer) {
+		d.onEvict = onEvict
+	}
+}
+
+// WithOnDial registers a callback invoked after every dial attempt for a
+// fresh (non-pooled) connection, with the elapsed time and the resulting
+// error, if any.
+func WithOnDial(onDial func(network, address string, elapsed time.Duration, err error)) Option {
+	return func(d *Dialer) {
+		d.onDial = onDial
+	}
+}
+
+func (d *Dialer) notifyEvict(conn *Conn, reason EvictReason) {
+	if d.onEvict != nil {
+		d.onEvict(conn, reason)
+	}
+}
+
+// dial wraps dialContext with the OnDial callback.
+func (d *Dialer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	start := d.nowFn()
+	rc, err := d.dialContext(ctx, network, address)
+	if d.onDial != nil {
+		d.onDial(network, address, d.nowFn().Sub(start), err)
+	}
+	return rc, err
+}
@@ -0,0 +1,149 @@
+package connpool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOnDialFiresForFreshDial(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	type call struct {
+		network, address string
+		err              error
+	}
+	var mu sync.Mutex
+	var calls []call
+	d := New(WithOnDial(func(network, address string, elapsed time.Duration, err error) {
+		mu.Lock()
+		calls = append(calls, call{network, address, err})
+		mu.Unlock()
+	}))
+	t.Cleanup(func() { d.Close() })
+
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 1 {
+		t.Fatalf("OnDial fired %d times, want 1", len(calls))
+	}
+	if calls[0].network != "tcp" || calls[0].address != ln.Addr().String() || calls[0].err != nil {
+		t.Fatalf("unexpected OnDial call: %+v", calls[0])
+	}
+}
+
+func TestValidatorRejectsCachedConn(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var evicted []EvictReason
+	reject := true
+	d := New(
+		WithValidator(func(ctx context.Context, c net.Conn) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if reject {
+				return errors.New("validator: rejected")
+			}
+			return nil
+		}),
+		WithOnEvict(func(c *Conn, r EvictReason) {
+			mu.Lock()
+			evicted = append(evicted, r)
+			mu.Unlock()
+		}),
+	)
+	t.Cleanup(func() { d.Close() })
+
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial after validator rejection: %v", err)
+	}
+	defer fresh.Close()
+
+	if fresh == conn {
+		t.Fatal("Dial returned the conn the Validator rejected")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	found := false
+	for _, r := range evicted {
+		if r == EvictValidationFailed {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an EvictValidationFailed eviction, got %v", evicted)
+	}
+}
+
+func TestWithOnEvictReportsIdleCapacity(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var evicted []EvictReason
+	d := New(
+		WithMaxIdleConns(1),
+		WithOnEvict(func(c *Conn, r EvictReason) {
+			mu.Lock()
+			evicted = append(evicted, r)
+			mu.Unlock()
+		}),
+	)
+	t.Cleanup(func() { d.Close() })
+
+	conn1, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn2, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	found := false
+	for _, r := range evicted {
+		if r == EvictIdleCapacity {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an EvictIdleCapacity eviction once MaxIdleConns was exceeded, got %v", evicted)
+	}
+}
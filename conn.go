@@ -6,12 +6,14 @@ import (
 	"time"
 )
 
-// now returns the current time; it's overridden in tests.
-var now = time.Now
-
 type cacheKey struct {
 	network string
 	address string
+	// tlsKey is empty for plain conns. For conns dialed via
+	// DialTLSContext it folds in the effective ServerName plus a hash of
+	// NextProtos/InsecureSkipVerify, so conns bound for different TLS
+	// configurations on the same address aren't mixed. See tls.go.
+	tlsKey string
 }
 
 // incomparable is a zero-width, non-comparable type. Adding it to a struct
@@ -42,7 +44,7 @@ func (d *Dialer) newConn(rc net.Conn, key cacheKey) *Conn {
 		rawConn:   rc,
 		cacheKey:  key,
 		closech:   make(chan struct{}),
-		createdAt: now(),
+		createdAt: d.nowFn(),
 		inUse:     true,
 	}
 }
@@ -61,7 +63,44 @@ func (c *Conn) expired(timeout time.Duration) bool {
 	if timeout <= 0 {
 		return false
 	}
-	return c.createdAt.Add(timeout).Before(now())
+	return c.createdAt.Add(timeout).Before(c.dialer.nowFn())
+}
+
+// idleExpired reports whether conn has been sitting idle in the pool,
+// i.e. unused since returnedAt, for longer than timeout.
+func (c *Conn) idleExpired(timeout time.Duration) bool {
+	if timeout <= 0 {
+		return false
+	}
+	return c.returnedAt.Add(timeout).Before(c.dialer.nowFn())
+}
+
+// isAlive probes a cached connection for liveness without consuming any
+// of its data, by peeking at the socket through its syscall.RawConn. It
+// reports false if the peer has closed the connection, or if there is
+// unexpected data sitting on it (the previous user left the protocol out
+// of sync). Conns whose underlying net.Conn doesn't implement
+// syscall.Conn can't be probed and are reported alive; they're rejected
+// at pool-put time instead, see putCacheConnLocked.
+func (c *Conn) isAlive() bool {
+	sc, ok := rawSyscallConn(c.rawConn)
+	if !ok {
+		return true
+	}
+
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	alive := true
+	if err := raw.Read(func(fd uintptr) bool {
+		alive = peekAlive(fd)
+		return true
+	}); err != nil {
+		return false
+	}
+	return alive
 }
 
 func (c *Conn) explicitClose() {
@@ -113,9 +152,14 @@ func (c *Conn) Close() (err error) {
 	defer c.checkErr(err)
 
 	c.dialer.mu.Lock()
-	defer c.dialer.mu.Unlock()
-	if !c.dialer.putCacheConnLocked(c) {
-		return c.rawConn.Close()
+	ok, putErr, reason := c.dialer.putCacheConnLocked(c)
+	c.dialer.mu.Unlock()
+	if !ok {
+		c.dialer.notifyEvict(c, reason)
+		if cerr := c.rawConn.Close(); cerr != nil {
+			return cerr
+		}
+		return putErr
 	}
 
 	return nil
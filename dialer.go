@@ -2,6 +2,7 @@ package connpool
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 	"time"
@@ -12,26 +13,158 @@ type Dialer struct {
 	// The default max idle connections is currently 2. This may change in a future release.
 	maxIdleConns int
 
+	// maxLifetime bounds how long a conn may live since it was created,
+	// regardless of how recently it was used.
 	maxLifetime time.Duration
 
+	// idleTimeout bounds how long a conn may sit unused in the pool since
+	// it was last returned, independent of maxLifetime.
+	idleTimeout time.Duration
+
+	// maxOpenConns caps the number of open (idle + in-use) conns per
+	// cacheKey. If n <= 0, the number of open conns is unlimited.
+	maxOpenConns int
+
+	// selfConnectRetries bounds how many times a dial is retried after
+	// connecting to itself, see avoidSelfConnect.
+	selfConnectRetries int
+
+	// validator, if set, is run against a cached conn's underlying
+	// net.Conn before DialContext returns it.
+	validator func(context.Context, net.Conn) error
+	// onEvict, if set, is notified whenever a pooled *Conn is discarded.
+	onEvict func(*Conn, EvictReason)
+	// onDial, if set, is notified after every fresh dial attempt.
+	onDial func(network, address string, elapsed time.Duration, err error)
+
 	baseDialer *net.Dialer
 
+	// nowFn returns the current time; it's a field (not a package var) so
+	// tests can override the clock for one Dialer without racing every
+	// other concurrently-running test's use of real time.
+	nowFn func() time.Time
+
 	cache  map[cacheKey][]*Conn
 	closed bool
 
+	// numOpen tracks open (idle + in-use) conns per cacheKey, enforcing
+	// maxOpenConns.
+	numOpen map[cacheKey]int
+	// waiters holds, per cacheKey, the queue of callers blocked in
+	// DialContext waiting for a conn to be freed by Conn.Close.
+	waiters map[cacheKey][]chan waiterSignal
+
+	waitCount    int64
+	waitDuration time.Duration
+
+	closech  chan struct{}
+	reaperWG sync.WaitGroup
+
 	mu sync.Mutex
 }
 
-func New() *Dialer {
+// New builds a Dialer. With no options it behaves like a plain
+// net.Dialer plus connection reuse: no idle or open connection limits.
+func New(opts ...Option) *Dialer {
 	d := &Dialer{
 		maxIdleConns: 0,
 		maxLifetime:  time.Second,
+		nowFn:        time.Now,
 		cache:        make(map[cacheKey][]*Conn),
+		numOpen:      make(map[cacheKey]int),
+		waiters:      make(map[cacheKey][]chan waiterSignal),
 		closed:       false,
+		closech:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	d.reaperWG.Add(1)
+	go d.reaper()
 	return d
 }
 
+// reaper periodically evicts and closes pooled conns that have exceeded
+// maxLifetime or idleTimeout, so conns for cache keys nobody dials again
+// don't sit in d.cache forever. It runs until Close stops it.
+func (d *Dialer) reaper() {
+	defer d.reaperWG.Done()
+
+	ticker := time.NewTicker(reaperInterval(d.idleTimeout, d.maxLifetime))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closech:
+			return
+		case <-ticker.C:
+			d.reapExpired()
+		}
+	}
+}
+
+// reaperInterval picks how often the reaper wakes up: half of whichever
+// of idleTimeout/maxLifetime is shorter, so an expired conn isn't left
+// around for much longer than its own bound. Falls back to a minute if
+// neither bound is set.
+func reaperInterval(idleTimeout, maxLifetime time.Duration) time.Duration {
+	var shortest time.Duration
+	for _, d := range [...]time.Duration{idleTimeout, maxLifetime} {
+		if d > 0 && (shortest == 0 || d < shortest) {
+			shortest = d
+		}
+	}
+	if shortest == 0 {
+		return time.Minute
+	}
+	if interval := shortest / 2; interval > 0 {
+		return interval
+	}
+	return time.Millisecond
+}
+
+func (d *Dialer) reapExpired() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, conns := range d.cache {
+		live := conns[:0]
+		for _, conn := range conns {
+			reason, dead := reapReason(conn, d.maxLifetime, d.idleTimeout)
+			if dead {
+				conn.rawConn.Close()
+				d.decrementOpenLocked(key)
+				d.notifyEvict(conn, reason)
+				continue
+			}
+			live = append(live, conn)
+		}
+		if len(live) == 0 {
+			delete(d.cache, key)
+		} else {
+			d.cache[key] = live
+		}
+	}
+}
+
+// reapReason reports whether conn should be reaped and, if so, why.
+func reapReason(conn *Conn, maxLifetime, idleTimeout time.Duration) (reason EvictReason, dead bool) {
+	switch {
+	case conn.expired(maxLifetime):
+		return EvictMaxLifetime, true
+	case conn.idleExpired(idleTimeout):
+		return EvictIdleTimeout, true
+	default:
+		return 0, false
+	}
+}
+
+func (d *Dialer) decrementOpenLocked(key cacheKey) {
+	if d.numOpen[key] > 0 {
+		d.numOpen[key]--
+	}
+}
+
 func (d *Dialer) Dial(network, address string) (net.Conn, error) {
 	return d.DialContext(context.Background(), network, address)
 }
@@ -41,28 +174,197 @@ var (
 	aLongTimeAgo = time.Unix(1, 0)
 )
 
+// errRetryWait is an internal control-flow sentinel: it never escapes
+// dialWithCache. It means a waiter was woken by a conn that turned out to
+// be unusable (it failed getCacheConnLocked's checks in
+// putCacheConnLocked, or it failed the Validator after handoff), and
+// should retry the whole dialWithCache attempt against the capacity that
+// freed up instead of being stuck waiting on a conn that no longer
+// exists.
+var errRetryWait = errors.New("connpool: retry wait")
+
 func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
 	key := cacheKey{
 		network: network,
 		address: address,
 	}
 
-	d.mu.Lock()
-	cachedConn := d.getCacheConnLocked(key)
-	if cachedConn != nil {
+	return d.dialWithCache(ctx, key, func(ctx context.Context) (net.Conn, error) {
+		rc, err := d.dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return d.avoidSelfConnect(ctx, network, address, rc)
+	})
+}
+
+// dialWithCache returns a cached conn for key if one passes the
+// Validator, otherwise dials a fresh one via dialFresh, respecting
+// MaxOpenConns. Used by both DialContext and DialTLSContext.
+func (d *Dialer) dialWithCache(ctx context.Context, key cacheKey, dialFresh func(context.Context) (net.Conn, error)) (net.Conn, error) {
+	for {
+		conn, err := d.dialWithCacheOnce(ctx, key, dialFresh)
+		if err == errRetryWait {
+			continue
+		}
+		return conn, err
+	}
+}
+
+// dialWithCacheOnce is dialWithCache's body for a single attempt. It
+// returns errRetryWait when a MaxOpenConns waiter was handed a conn that
+// turned out to be unusable (expired, dead, or Validator-rejected), so
+// the caller should loop and try again against the capacity that just
+// freed up rather than fail the whole DialContext call.
+func (d *Dialer) dialWithCacheOnce(ctx context.Context, key cacheKey, dialFresh func(context.Context) (net.Conn, error)) (net.Conn, error) {
+	for {
+		d.mu.Lock()
+		cachedConn := d.getCacheConnLocked(key)
 		d.mu.Unlock()
+		if cachedConn == nil {
+			break
+		}
+		if d.validator == nil {
+			return cachedConn, nil
+		}
+		if err := d.validator(ctx, cachedConn.rawConn); err != nil {
+			d.discardConn(cachedConn, EvictValidationFailed)
+			continue
+		}
 		return cachedConn, nil
 	}
+
+	d.mu.Lock()
+	if d.maxOpenConns > 0 && d.numOpen[key] >= d.maxOpenConns {
+		// waitForConnLocked unlocks d.mu itself.
+		conn, err := d.waitForConnLocked(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		// A handed-off conn only went through the expiry/liveness gate
+		// getCacheConnLocked applies (see putCacheConnLocked); it still
+		// needs the Validator, same as a conn taken from the idle cache.
+		if d.validator != nil {
+			if verr := d.validator(ctx, conn.rawConn); verr != nil {
+				d.discardConn(conn, EvictValidationFailed)
+				return nil, errRetryWait
+			}
+		}
+		return conn, nil
+	}
+	d.numOpen[key]++
 	d.mu.Unlock()
 
-	rc, err := d.dialContext(ctx, network, address)
+	rc, err := dialFresh(ctx)
 	if err != nil {
+		d.mu.Lock()
+		d.numOpen[key]--
+		d.mu.Unlock()
 		return nil, err
 	}
 
 	return d.newConn(rc, key), nil
 }
 
+// discardConn closes conn and drops it from the open count, notifying
+// OnEvict. Used for conns discarded outside the normal reap/get paths,
+// e.g. by a failed Validator.
+func (d *Dialer) discardConn(conn *Conn, reason EvictReason) {
+	conn.rawConn.Close()
+	d.mu.Lock()
+	d.decrementOpenLocked(conn.cacheKey)
+	d.mu.Unlock()
+	d.notifyEvict(conn, reason)
+}
+
+// waiterSignal is sent to a caller blocked in waitForConnLocked by the
+// Conn.Close that frees up its slot. conn is set when a usable conn was
+// handed off directly; retry is set when the conn putCacheConnLocked had
+// on hand for this waiter failed the expiry/liveness gate and was
+// discarded instead, meaning the waiter should redial against the
+// capacity that just freed up.
+type waiterSignal struct {
+	conn  *Conn
+	retry bool
+}
+
+// waitForConnLocked enqueues a waiter for key and blocks until a conn is
+// handed to it by a concurrent Conn.Close, the context is done, or the
+// Dialer is closed. d.mu must be held on entry; waitForConnLocked
+// releases it before blocking and does not re-acquire it before
+// returning.
+func (d *Dialer) waitForConnLocked(ctx context.Context, key cacheKey) (*Conn, error) {
+	ch := make(chan waiterSignal, 1)
+	d.waiters[key] = append(d.waiters[key], ch)
+	d.waitCount++
+	start := d.nowFn()
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		d.waitDuration += d.nowFn().Sub(start)
+		d.mu.Unlock()
+	}()
+
+	select {
+	case sig := <-ch:
+		if sig.retry {
+			return nil, errRetryWait
+		}
+		if sig.conn == nil {
+			return nil, ErrDialerClosed
+		}
+		sig.conn.inUse = true
+		return sig.conn, nil
+	case <-ctx.Done():
+		d.mu.Lock()
+		removed := removeWaiterLocked(d.waiters, key, ch)
+		d.mu.Unlock()
+		if !removed {
+			// A conn was concurrently handed to us right as we gave up;
+			// don't leak it.
+			if sig := <-ch; sig.conn != nil {
+				sig.conn.Close()
+			}
+		}
+		return nil, ctx.Err()
+	case <-d.closech:
+		return nil, ErrDialerClosed
+	}
+}
+
+func removeWaiterLocked(waiters map[cacheKey][]chan waiterSignal, key cacheKey, ch chan waiterSignal) bool {
+	q := waiters[key]
+	for i, c := range q {
+		if c == ch {
+			q = append(q[:i], q[i+1:]...)
+			if len(q) == 0 {
+				delete(waiters, key)
+			} else {
+				waiters[key] = q
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// popWaiterLocked removes and returns the oldest caller blocked in
+// DialContext for key, if any.
+func (d *Dialer) popWaiterLocked(key cacheKey) (chan waiterSignal, bool) {
+	q := d.waiters[key]
+	if len(q) == 0 {
+		return nil, false
+	}
+	ch := q[0]
+	if len(q) == 1 {
+		delete(d.waiters, key)
+	} else {
+		d.waiters[key] = q[1:]
+	}
+	return ch, true
+}
+
 func (d *Dialer) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
 	if d.baseDialer != nil {
 		return d.baseDialer.DialContext(ctx, network, address)
@@ -73,27 +375,75 @@ func (d *Dialer) dialContext(ctx context.Context, network, address string) (net.
 
 func (d *Dialer) Close() error {
 	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
 	d.closed = true
 	d.mu.Unlock()
 
-	for _, conns := range d.cache {
-		for _, conn := range conns {
-			conn.Close()
-		}
+	close(d.closech)
+	d.reaperWG.Wait()
+
+	// Snapshot the idle conns under d.mu rather than ranging over
+	// d.cache directly: conn.Close below takes d.mu itself, and a
+	// concurrent DialContext reaching getCacheConnLocked mutates d.cache
+	// under lock too, so an unlocked range here would race it.
+	d.mu.Lock()
+	var conns []*Conn
+	for _, cs := range d.cache {
+		conns = append(conns, cs...)
+	}
+	d.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
 	}
 	return nil
 }
 
-func (d *Dialer) putCacheConnLocked(conn *Conn) bool {
+func (d *Dialer) putCacheConnLocked(conn *Conn) (ok bool, err error, reason EvictReason) {
 	if d.closed {
-		return false
+		d.decrementOpenLocked(conn.cacheKey)
+		return false, nil, EvictDialerClosed
 	}
 	if conn.isBroken() {
-		return false
+		d.decrementOpenLocked(conn.cacheKey)
+		return false, nil, EvictBroken
 	}
+	if _, ok := rawSyscallConn(conn.rawConn); !ok {
+		d.decrementOpenLocked(conn.cacheKey)
+		return false, ErrNotSyscallConn, EvictNotPoolable
+	}
+
+	// A caller blocked on MaxOpenConns takes priority over the idle
+	// cache: it's already waiting, so don't make it wait longer just to
+	// have this conn sit idle instead. But it only gets the conn if the
+	// conn would also survive getCacheConnLocked's own gate; otherwise
+	// the waiter is told to retry against the capacity this eviction
+	// just freed up instead of being handed something already dead.
+	if ch, waiting := d.popWaiterLocked(conn.cacheKey); waiting {
+		if reason, dead := getCacheReason(conn, d.maxLifetime, d.idleTimeout); dead {
+			conn.rawConn.Close()
+			d.decrementOpenLocked(conn.cacheKey)
+			d.notifyEvict(conn, reason)
+			ch <- waiterSignal{retry: true}
+			return true, nil, 0
+		}
+		conn.returnedAt = d.nowFn()
+		ch <- waiterSignal{conn: conn}
+		return true, nil, 0
+	}
+
+	if d.maxIdleConns > 0 && len(d.cache[conn.cacheKey]) >= d.maxIdleConns {
+		d.decrementOpenLocked(conn.cacheKey)
+		return false, nil, EvictIdleCapacity
+	}
+
 	conn.inUse = false
+	conn.returnedAt = d.nowFn()
 	d.cache[conn.cacheKey] = append(d.cache[conn.cacheKey], conn)
-	return true
+	return true, nil, 0
 }
 
 func (d *Dialer) getCacheConnLocked(key cacheKey) *Conn {
@@ -103,15 +453,41 @@ func (d *Dialer) getCacheConnLocked(key cacheKey) *Conn {
 	}
 
 	for i, conn := range conns {
-		if conn.expired(d.maxLifetime) || conn.isBroken() {
+		reason, dead := getCacheReason(conn, d.maxLifetime, d.idleTimeout)
+		if dead {
 			conn.rawConn.Close()
+			d.decrementOpenLocked(key)
+			d.notifyEvict(conn, reason)
 			continue
 		}
 
-		copy(d.cache[key], conns[i:])
+		if rest := conns[i+1:]; len(rest) == 0 {
+			delete(d.cache, key)
+		} else {
+			d.cache[key] = rest
+		}
 		conn.inUse = true
 		return conn
 	}
 
+	delete(d.cache, key)
 	return nil
 }
+
+// getCacheReason extends reapReason with the checks run only at
+// selection time: isBroken and the isAlive probe. reapExpired applies
+// neither, so a conn whose peer closed while it sat idle stays cached
+// until it's selected or its own bounds expire.
+func getCacheReason(conn *Conn, maxLifetime, idleTimeout time.Duration) (reason EvictReason, dead bool) {
+	if reason, dead := reapReason(conn, maxLifetime, idleTimeout); dead {
+		return reason, dead
+	}
+	switch {
+	case conn.isBroken():
+		return EvictBroken, true
+	case !conn.isAlive():
+		return EvictDead, true
+	default:
+		return 0, false
+	}
+}
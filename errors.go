@@ -0,0 +1,19 @@
+package connpool
+
+import "errors"
+
+// ErrNotSyscallConn is returned from Conn.Close when the underlying
+// net.Conn does not implement syscall.Conn (for example a user-supplied
+// wrapper; a *tls.Conn is unwrapped to its own underlying conn first), so
+// the pre-reuse liveness probe cannot run on it. The conn is still closed; it is just not eligible for pooling.
+var ErrNotSyscallConn = errors.New("connpool: underlying conn does not implement syscall.Conn")
+
+// ErrDialerClosed is returned by DialContext when it was blocked waiting
+// for a connection under MaxOpenConns and the Dialer was closed before
+// one became available.
+var ErrDialerClosed = errors.New("connpool: dialer closed while waiting for a connection")
+
+// ErrSelfConnect is returned by DialContext when every dial attempt,
+// including retries, raced an ephemeral port assignment that connected
+// the socket to itself.
+var ErrSelfConnect = errors.New("connpool: dial connected to itself")
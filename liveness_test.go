@@ -0,0 +1,116 @@
+package connpool
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+// waitUntil polls cond until it reports true or timeout elapses,
+// returning whether cond ever succeeded. Useful here because a peer's
+// close takes an unbounded (if short) amount of time to reach the local
+// socket.
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConnIsAliveDetectsPeerClose(t *testing.T) {
+	t.Parallel()
+
+	ln, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c
+	}()
+
+	rc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rc.Close() })
+
+	conn := &Conn{rawConn: rc}
+	if !conn.isAlive() {
+		t.Fatal("freshly dialed conn reported dead")
+	}
+
+	server := <-accepted
+	server.Close()
+
+	if !waitUntil(2*time.Second, func() bool { return !conn.isAlive() }) {
+		t.Fatal("isAlive still reports alive after the peer performed an orderly shutdown")
+	}
+}
+
+func TestGetCacheConnLockedSkipsPeerClosedConn(t *testing.T) {
+	t.Parallel()
+
+	ln, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- c
+	}()
+
+	d := New()
+	t.Cleanup(func() { d.Close() })
+
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer closes while the conn is sitting idle in the pool.
+	server.Close()
+	if !waitUntil(2*time.Second, func() bool {
+		stats := d.Stats()
+		return stats.IdleConns == 1
+	}) {
+		t.Fatal("conn never made it into the idle cache")
+	}
+
+	fresh, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+
+	if fresh == conn {
+		t.Fatal("Dial returned the peer-closed conn instead of a fresh one")
+	}
+	if stats := d.Stats(); stats.IdleConns != 0 {
+		t.Fatalf("idle conns = %d, want 0 (the dead conn should have been reaped, not recached)", stats.IdleConns)
+	}
+}
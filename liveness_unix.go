@@ -0,0 +1,28 @@
+//go:build !windows
+
+package connpool
+
+import "syscall"
+
+// peekAlive performs a non-blocking MSG_PEEK recv on fd to determine
+// whether a pooled connection is still usable without consuming any of
+// its data.
+func peekAlive(fd uintptr) (alive bool) {
+	buf := make([]byte, 1)
+	n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+	switch {
+	case err == syscall.EAGAIN || err == syscall.EWOULDBLOCK:
+		// Nothing pending, but the socket is still open.
+		return true
+	case err != nil:
+		return false
+	case n == 0:
+		// The peer performed an orderly shutdown.
+		return false
+	default:
+		// Data is sitting unread on an idle pooled conn, which means
+		// whoever used it last left the protocol out of sync. Not safe
+		// to hand out.
+		return false
+	}
+}
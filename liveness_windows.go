@@ -0,0 +1,35 @@
+//go:build windows
+
+package connpool
+
+import "syscall"
+
+// peekAlive performs a non-blocking WSARecv with MSG_PEEK on fd to
+// determine whether a pooled connection is still usable without
+// consuming any of its data. It uses a real (non-zero) receive buffer,
+// since a zero-length WSARecv can't distinguish "nothing pending" from
+// "peer performed an orderly shutdown" - both report n == 0 with a nil
+// error.
+func peekAlive(fd uintptr) (alive bool) {
+	buf := make([]byte, 1)
+	wsabuf := syscall.WSABuf{Len: uint32(len(buf)), Buf: &buf[0]}
+	var n, flags uint32
+	flags = syscall.MSG_PEEK
+
+	err := syscall.WSARecv(syscall.Handle(fd), &wsabuf, 1, &n, &flags, nil, nil)
+	switch {
+	case err == syscall.WSAEWOULDBLOCK:
+		// Nothing pending, but the socket is still open.
+		return true
+	case err != nil:
+		return false
+	case n == 0:
+		// The peer performed an orderly shutdown.
+		return false
+	default:
+		// Data is sitting unread on an idle pooled conn, which means
+		// whoever used it last left the protocol out of sync. Not safe
+		// to hand out.
+		return false
+	}
+}
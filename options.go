@@ -0,0 +1,79 @@
+package connpool
+
+import (
+	"net"
+	"time"
+)
+
+// Option configures a Dialer constructed via New.
+type Option func(*Dialer)
+
+// WithMaxIdleConns sets the maximum number of idle connections kept per
+// cacheKey. If n <= 0, the number of idle connections is unlimited.
+func WithMaxIdleConns(n int) Option {
+	return func(d *Dialer) {
+		d.maxIdleConns = n
+	}
+}
+
+// WithMaxLifetime bounds how long a conn may live since it was created,
+// regardless of how recently it was used. A timeout <= 0 means no limit.
+func WithMaxLifetime(timeout time.Duration) Option {
+	return func(d *Dialer) {
+		d.maxLifetime = timeout
+	}
+}
+
+// WithIdleTimeout bounds how long a conn may sit unused in the pool
+// since it was last returned, independent of MaxLifetime. A timeout <= 0
+// means no limit.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(d *Dialer) {
+		d.idleTimeout = timeout
+	}
+}
+
+// WithBaseDialer overrides the *net.Dialer used to establish new
+// connections. If not set, a zero-value net.Dialer is used.
+func WithBaseDialer(base *net.Dialer) Option {
+	return func(d *Dialer) {
+		d.baseDialer = base
+	}
+}
+
+// WithKeepAlive enables TCP keepalive on the base dialer, probing every
+// interval after idle and giving up after count unacknowledged probes.
+func WithKeepAlive(idle, interval time.Duration, count int) Option {
+	return func(d *Dialer) {
+		if d.baseDialer == nil {
+			d.baseDialer = &net.Dialer{}
+		}
+		d.baseDialer.KeepAliveConfig = net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     idle,
+			Interval: interval,
+			Count:    count,
+		}
+	}
+}
+
+// WithMaxOpenConns sets the maximum number of connections (idle plus
+// in-use) allowed per cacheKey, mirroring database/sql.DB.SetMaxOpenConns.
+// If n <= 0, the number of open connections is unlimited. Once the limit
+// is reached, DialContext blocks until a connection is returned to the
+// pool via Conn.Close, the context is done, or the Dialer is closed.
+func WithMaxOpenConns(n int) Option {
+	return func(d *Dialer) {
+		d.maxOpenConns = n
+	}
+}
+
+// WithSelfConnectRetries sets how many times a dial is retried after
+// detecting that it raced an ephemeral port assignment and connected to
+// itself, before DialContext gives up with ErrSelfConnect. n <= 0 uses
+// defaultSelfConnectRetries.
+func WithSelfConnectRetries(n int) Option {
+	return func(d *Dialer) {
+		d.selfConnectRetries = n
+	}
+}
@@ -0,0 +1,91 @@
+package connpool
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReaperEvictsIdleConn(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var evicted []EvictReason
+	d := New(
+		WithIdleTimeout(20*time.Millisecond),
+		WithOnEvict(func(c *Conn, r EvictReason) {
+			mu.Lock()
+			evicted = append(evicted, r)
+			mu.Unlock()
+		}),
+	)
+	t.Cleanup(func() { d.Close() })
+
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitUntil(2*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range evicted {
+			if r == EvictIdleTimeout {
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Fatal("reaper never evicted the idle conn")
+	}
+
+	if stats := d.Stats(); stats.IdleConns != 0 || stats.OpenConns != 0 {
+		t.Fatalf("stats after reap = %+v, want all zero", stats)
+	}
+}
+
+func TestReaperEvictsExpiredConn(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var evicted []EvictReason
+	d := New(
+		WithMaxLifetime(20*time.Millisecond),
+		WithOnEvict(func(c *Conn, r EvictReason) {
+			mu.Lock()
+			evicted = append(evicted, r)
+			mu.Unlock()
+		}),
+	)
+	t.Cleanup(func() { d.Close() })
+
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitUntil(2*time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, r := range evicted {
+			if r == EvictMaxLifetime {
+				return true
+			}
+		}
+		return false
+	}) {
+		t.Fatal("reaper never evicted the expired conn")
+	}
+}
@@ -0,0 +1,69 @@
+package connpool
+
+import (
+	"context"
+	"net"
+)
+
+// defaultSelfConnectRetries is how many times a dial is retried after
+// detecting a self-connect before giving up, when the Dialer wasn't
+// built with WithSelfConnectRetries.
+const defaultSelfConnectRetries = 2
+
+// avoidSelfConnect re-dials rc up to the configured number of retries if
+// the kernel raced an ephemeral port assignment such that rc ended up
+// connected to itself (local and remote address equal). A self-connected
+// socket echoes back everything written to it as if it were the peer,
+// which would be silently returned from the pool again and again.
+func (d *Dialer) avoidSelfConnect(ctx context.Context, network, address string, rc net.Conn) (net.Conn, error) {
+	if !selfConnectCheckable(network) {
+		return rc, nil
+	}
+
+	retries := d.selfConnectRetries
+	if retries <= 0 {
+		retries = defaultSelfConnectRetries
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !isSelfConnect(rc) {
+			return rc, nil
+		}
+		rc.Close()
+		if attempt >= retries {
+			return nil, ErrSelfConnect
+		}
+
+		redialed, err := d.dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		rc = redialed
+	}
+}
+
+// selfConnectCheckable reports whether network uses addresses for which
+// a self-connect is possible and comparable. Unix-domain sockets don't
+// race ephemeral ports the same way, and their addresses aren't
+// comparable in the same sense.
+func selfConnectCheckable(network string) bool {
+	switch network {
+	case "unix", "unixgram", "unixpacket":
+		return false
+	default:
+		return true
+	}
+}
+
+func isSelfConnect(rc net.Conn) bool {
+	switch local := rc.LocalAddr().(type) {
+	case *net.TCPAddr:
+		remote, ok := rc.RemoteAddr().(*net.TCPAddr)
+		return ok && local.Port == remote.Port && local.IP.Equal(remote.IP)
+	case *net.UDPAddr:
+		remote, ok := rc.RemoteAddr().(*net.UDPAddr)
+		return ok && local.Port == remote.Port && local.IP.Equal(remote.IP)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,107 @@
+package connpool
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSelfConnectCheckable(t *testing.T) {
+	cases := map[string]bool{
+		"tcp":        true,
+		"tcp4":       true,
+		"udp":        true,
+		"unix":       false,
+		"unixgram":   false,
+		"unixpacket": false,
+	}
+	for network, want := range cases {
+		if got := selfConnectCheckable(network); got != want {
+			t.Errorf("selfConnectCheckable(%q) = %v, want %v", network, got, want)
+		}
+	}
+}
+
+func TestIsSelfConnect(t *testing.T) {
+	addrA := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+	addrB := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5678}
+
+	same := &fakeAddrConn{local: addrA, remote: addrA}
+	if !isSelfConnect(same) {
+		t.Error("equal local/remote TCPAddr should be a self-connect")
+	}
+
+	different := &fakeAddrConn{local: addrA, remote: addrB}
+	if isSelfConnect(different) {
+		t.Error("distinct local/remote TCPAddr should not be a self-connect")
+	}
+}
+
+// fakeAddrConn is a net.Conn stand-in whose only implemented methods are
+// the address accessors isSelfConnect looks at.
+type fakeAddrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (f *fakeAddrConn) LocalAddr() net.Addr  { return f.local }
+func (f *fakeAddrConn) RemoteAddr() net.Addr { return f.remote }
+
+// selfConnectOverride wraps a real net.Conn but reports forced addresses,
+// so avoidSelfConnect's detection can be triggered deterministically
+// against a real socket without racing an actual ephemeral-port
+// self-connect.
+type selfConnectOverride struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (s *selfConnectOverride) LocalAddr() net.Addr  { return s.local }
+func (s *selfConnectOverride) RemoteAddr() net.Addr { return s.remote }
+
+func TestAvoidSelfConnectFiresOnDialPerRetry(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var calls []error
+	d := New(
+		WithSelfConnectRetries(1),
+		WithOnDial(func(network, address string, elapsed time.Duration, err error) {
+			mu.Lock()
+			calls = append(calls, err)
+			mu.Unlock()
+		}),
+	)
+	t.Cleanup(func() { d.Close() })
+
+	rc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Force this conn to look self-connected so avoidSelfConnect retries.
+	fake := &selfConnectOverride{Conn: rc, local: rc.RemoteAddr(), remote: rc.RemoteAddr()}
+
+	got, err := d.avoidSelfConnect(context.Background(), "tcp", ln.Addr().String(), fake)
+	if err != nil {
+		t.Fatalf("avoidSelfConnect: %v", err)
+	}
+	defer got.Close()
+
+	if isSelfConnect(got) {
+		t.Fatal("redialed conn still looks self-connected")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) != 1 {
+		t.Fatalf("OnDial fired %d times, want 1 (the retry dial)", len(calls))
+	}
+	if calls[0] != nil {
+		t.Fatalf("OnDial reported error %v for a successful retry", calls[0])
+	}
+}
@@ -0,0 +1,36 @@
+package connpool
+
+import "time"
+
+// Stats holds aggregate pool statistics across all cache keys, modeled
+// after database/sql.DBStats.
+type Stats struct {
+	OpenConns int // OpenConns is the number of open connections, idle plus in-use.
+	IdleConns int // IdleConns is the number of idle connections currently cached.
+	InUse     int // InUse is the number of connections currently checked out.
+
+	WaitCount    int64         // WaitCount is the total number of DialContext calls that had to wait for MaxOpenConns.
+	WaitDuration time.Duration // WaitDuration is the total time spent waiting.
+}
+
+// Stats returns pool statistics.
+func (d *Dialer) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var open, idle int
+	for _, n := range d.numOpen {
+		open += n
+	}
+	for _, conns := range d.cache {
+		idle += len(conns)
+	}
+
+	return Stats{
+		OpenConns:    open,
+		IdleConns:    idle,
+		InUse:        open - idle,
+		WaitCount:    d.waitCount,
+		WaitDuration: d.waitDuration,
+	}
+}
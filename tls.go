@@ -0,0 +1,89 @@
+package connpool
+
+import (
+	"context"
+	"crypto/tls"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// DialTLSContext is like DialContext, but performs a TLS handshake over
+// the dialed conn using cfg and pools the result. Conns are keyed not
+// just by network/address but by the effective TLS identity (ServerName
+// plus NextProtos/InsecureSkipVerify), so a cached conn for one SNI
+// hostname is never handed to a caller expecting another. cfg is reused
+// as-is (cloned only to fill in ServerName if empty), so its
+// SessionTicketsDisabled setting and ClientSessionCache compose normally
+// with pooled-conn reuse instead of fighting it.
+func (d *Dialer) DialTLSContext(ctx context.Context, network, address string, cfg *tls.Config) (net.Conn, error) {
+	cfg = effectiveTLSConfig(cfg, address)
+	key := cacheKey{
+		network: network,
+		address: address,
+		tlsKey:  tlsCacheKey(cfg),
+	}
+
+	return d.dialWithCache(ctx, key, func(ctx context.Context) (net.Conn, error) {
+		rc, err := d.dial(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		rc, err = d.avoidSelfConnect(ctx, network, address, rc)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn := tls.Client(rc, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			tlsConn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	})
+}
+
+// effectiveTLSConfig fills in cfg.ServerName from address when unset,
+// mirroring what tls.DialWithDialer does, so the cache key and the
+// handshake agree on which hostname is being verified.
+func effectiveTLSConfig(cfg *tls.Config, address string) *tls.Config {
+	if cfg.ServerName != "" {
+		return cfg
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	cfg = cfg.Clone()
+	cfg.ServerName = host
+	return cfg
+}
+
+// tlsCacheKey folds the parts of cfg that change which peer a conn is
+// actually good for into a single comparable string: the ServerName
+// verbatim, plus a hash of NextProtos (ALPN affects which protocol the
+// handshake negotiates) and InsecureSkipVerify.
+func tlsCacheKey(cfg *tls.Config) string {
+	h := fnv.New64a()
+	for _, proto := range cfg.NextProtos {
+		io.WriteString(h, proto)
+		h.Write([]byte{0})
+	}
+	if cfg.InsecureSkipVerify {
+		h.Write([]byte{1})
+	}
+	return cfg.ServerName + "|" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+// rawSyscallConn unwraps a *tls.Conn to its underlying net.Conn before
+// asserting syscall.Conn, since tls.Conn itself doesn't implement
+// syscall.Conn; everything else is asserted directly.
+func rawSyscallConn(c net.Conn) (syscall.Conn, bool) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	sc, ok := c.(syscall.Conn)
+	return sc, ok
+}
@@ -0,0 +1,136 @@
+package connpool
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/net/nettest"
+)
+
+func generateTestCert(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+}
+
+// TestDialTLSContextKeysBySNI dials the same network address under two
+// different ServerName identities and checks the pool keeps them as
+// separate cacheKeys instead of coalescing them.
+func TestDialTLSContextKeysBySNI(t *testing.T) {
+	t.Parallel()
+
+	certA := generateTestCert(t, "a.test")
+	certB := generateTestCert(t, "b.test")
+
+	serverCfg := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName == "b.test" {
+				return &certB, nil
+			}
+			return &certA, nil
+		},
+	}
+
+	ln, err := nettest.NewLocalListener("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	tlsLn := tls.NewListener(ln, serverCfg)
+
+	go func() {
+		for {
+			c, err := tlsLn.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, c)
+		}
+	}()
+
+	d := New()
+	t.Cleanup(func() { d.Close() })
+
+	connA, err := d.DialTLSContext(context.Background(), "tcp", ln.Addr().String(), &tls.Config{ServerName: "a.test", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("dial a.test: %v", err)
+	}
+	connB, err := d.DialTLSContext(context.Background(), "tcp", ln.Addr().String(), &tls.Config{ServerName: "b.test", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("dial b.test: %v", err)
+	}
+
+	if err := connA.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := connB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := d.Stats(); stats.IdleConns != 2 {
+		t.Fatalf("idle conns = %d, want 2 (one per SNI identity, not coalesced)", stats.IdleConns)
+	}
+
+	reused, err := d.DialTLSContext(context.Background(), "tcp", ln.Addr().String(), &tls.Config{ServerName: "a.test", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("dial a.test again: %v", err)
+	}
+	defer reused.Close()
+
+	if reused != connA {
+		t.Fatal("DialTLSContext for a.test did not reuse the cached a.test conn")
+	}
+	if stats := d.Stats(); stats.IdleConns != 1 {
+		t.Fatalf("idle conns after reuse = %d, want 1 (only b.test's conn left idle)", stats.IdleConns)
+	}
+}
+
+func TestTLSCacheKeyDiffersByServerNameAndALPN(t *testing.T) {
+	a := tlsCacheKey(&tls.Config{ServerName: "a.test"})
+	b := tlsCacheKey(&tls.Config{ServerName: "b.test"})
+	if a == b {
+		t.Fatal("different ServerName produced the same tlsCacheKey")
+	}
+
+	plain := tlsCacheKey(&tls.Config{ServerName: "a.test"})
+	withALPN := tlsCacheKey(&tls.Config{ServerName: "a.test", NextProtos: []string{"h2"}})
+	if plain == withALPN {
+		t.Fatal("different NextProtos produced the same tlsCacheKey")
+	}
+
+	insecure := tlsCacheKey(&tls.Config{ServerName: "a.test", InsecureSkipVerify: true})
+	if plain == insecure {
+		t.Fatal("different InsecureSkipVerify produced the same tlsCacheKey")
+	}
+}
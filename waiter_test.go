@@ -0,0 +1,162 @@
+package connpool
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock lets tests advance the pool's notion of "now" deterministically
+// instead of racing real sleeps against MaxLifetime/IdleTimeout.
+type fakeClock struct {
+	mu  sync.Mutex
+	cur time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{cur: time.Now()}
+}
+
+func (f *fakeClock) now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cur
+}
+
+func (f *fakeClock) advance(d time.Duration) {
+	f.mu.Lock()
+	f.cur = f.cur.Add(d)
+	f.mu.Unlock()
+}
+
+// TestMaxOpenConnsWaiterDoesNotReceiveExpiredConn reproduces the scenario
+// where a conn handed to a MaxOpenConns waiter had already exceeded
+// MaxLifetime: the waiter must not receive it verbatim, it must get a
+// freshly dialed replacement instead.
+func TestMaxOpenConnsWaiterDoesNotReceiveExpiredConn(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	clock := newFakeClock()
+
+	var mu sync.Mutex
+	var evicted []EvictReason
+	d := New(
+		WithMaxOpenConns(1),
+		WithMaxLifetime(50*time.Millisecond),
+		WithOnEvict(func(c *Conn, r EvictReason) {
+			mu.Lock()
+			evicted = append(evicted, r)
+			mu.Unlock()
+		}),
+	)
+	d.nowFn = clock.now
+	t.Cleanup(func() { d.Close() })
+
+	conn1, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.advance(100 * time.Millisecond)
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 1)
+	go func() {
+		c, err := d.Dial("tcp", ln.Addr().String())
+		results <- dialResult{c, err}
+	}()
+
+	// Give the second Dial a chance to block as a waiter under
+	// MaxOpenConns before we free the only slot.
+	if !waitUntil(2*time.Second, func() bool {
+		return d.Stats().WaitCount > 0
+	}) {
+		t.Fatal("second Dial never queued as a waiter")
+	}
+
+	if err := conn1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatalf("waiter's Dial failed: %v", r.err)
+		}
+		defer r.conn.Close()
+		if r.conn == conn1 {
+			t.Fatal("waiter received the already-expired conn verbatim instead of a fresh dial")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter's Dial never completed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	found := false
+	for _, r := range evicted {
+		if r == EvictMaxLifetime {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an EvictMaxLifetime eviction for the expired conn, got %v", evicted)
+	}
+}
+
+// TestMaxOpenConnsWaiterReceivesLiveConn is the non-regression companion:
+// a live conn handed off to a waiter is reused as-is, not redialed.
+func TestMaxOpenConnsWaiterReceivesLiveConn(t *testing.T) {
+	t.Parallel()
+
+	ln := newEchoServer(t, "tcp")
+	t.Cleanup(func() { ln.Close() })
+
+	d := New(WithMaxOpenConns(1))
+	t.Cleanup(func() { d.Close() })
+
+	conn1, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan dialResult, 1)
+	go func() {
+		c, err := d.Dial("tcp", ln.Addr().String())
+		results <- dialResult{c, err}
+	}()
+
+	if !waitUntil(2*time.Second, func() bool {
+		return d.Stats().WaitCount > 0
+	}) {
+		t.Fatal("second Dial never queued as a waiter")
+	}
+
+	if err := conn1.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatalf("waiter's Dial failed: %v", r.err)
+		}
+		defer r.conn.Close()
+		if r.conn != conn1 {
+			t.Fatal("waiter did not receive the handed-off live conn")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("waiter's Dial never completed")
+	}
+}